@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BatchTarget is one entry of the targets array in a POST /batch body.
+type BatchTarget struct {
+	Remote     string `json:"remote"`
+	ServerName string `json:"servername"`
+	Method     string `json:"method"`
+	Curves     string `json:"curves"`
+	ALPN       string `json:"alpn"`
+	Insecure   bool   `json:"insecure"`
+
+	// Cert, Key and RootCAs are the batch equivalents of the cert=, key=
+	// and rootCAs= form fields handler accepts: each either inline PEM or
+	// a filename under -cert-dir.
+	Cert    string `json:"cert"`
+	Key     string `json:"key"`
+	RootCAs string `json:"rootCAs"`
+}
+
+// BatchRequest is the body of a POST /batch request.
+type BatchRequest struct {
+	Targets     []BatchTarget `json:"targets"`
+	Concurrency int           `json:"concurrency"`
+	Timeout     string        `json:"timeout"`
+}
+
+// BatchResult is one line of the NDJSON response to POST /batch.
+type BatchResult struct {
+	Index      int         `json:"index"`
+	Remote     string      `json:"remote"`
+	Ok         bool        `json:"ok"`
+	Kex        tls.CurveID `json:"kex,omitempty"`
+	KexName    string      `json:"kexName,omitempty"`
+	HRR        bool        `json:"hrr,omitempty"`
+	Telemetry  *Telemetry  `json:"telemetry,omitempty"`
+	ErrorClass string      `json:"errorClass,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// batchHandshake runs a single target and classifies any failure as a
+// config error (bad method, curves, cert, key or rootCAs), a dial error, a
+// handshake alert, or a timeout.
+func batchHandshake(ctx context.Context, t BatchTarget, timeout time.Duration) BatchResult {
+	res := BatchResult{Remote: t.Remote}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	curves, err := defaultCurvesForMethod(t.Method)
+	if err != nil {
+		res.ErrorClass = "config"
+		res.Error = err.Error()
+		return res
+	}
+	if t.Curves != "" {
+		curves, err = parseCurves(t.Curves)
+		if err != nil {
+			res.ErrorClass = "config"
+			res.Error = err.Error()
+			return res
+		}
+	}
+
+	serverName := t.ServerName
+	if serverName == "" {
+		if host, _, err := net.SplitHostPort(t.Remote); err == nil {
+			serverName = host
+		}
+	}
+
+	clientCert, err := clientCertificate(t.Cert, t.Key)
+	if err != nil {
+		res.ErrorClass = "config"
+		res.Error = err.Error()
+		return res
+	}
+	rootCAs, err := rootCAPool(t.RootCAs)
+	if err != nil {
+		res.ErrorClass = "config"
+		res.Error = err.Error()
+		return res
+	}
+
+	conn, newConn, tcpConn, ctx, dialDuration, err := dialTLS(ctx, t.Remote, serverName, dialOptions{
+		Curves:   curves,
+		ALPN:     t.ALPN,
+		Insecure: t.Insecure,
+		Cert:     clientCert,
+		RootCAs:  rootCAs,
+	})
+	if err != nil {
+		res.ErrorClass = "dial"
+		res.Error = err.Error()
+		return res
+	}
+	defer tcpConn.Close()
+	defer conn.Close()
+
+	inflight.Inc()
+	handshakeStart := time.Now()
+	err = conn.HandshakeContext(ctx)
+	handshakeDuration := time.Since(handshakeStart)
+	inflight.Dec()
+	if err != nil {
+		if ctx.Err() != nil {
+			res.ErrorClass = "timeout"
+		} else {
+			res.ErrorClass = "alert"
+		}
+		res.Error = err.Error()
+		observeHandshake("client", curveName(newConn.kex), newConn.hrr, "error", handshakeDuration)
+		return res
+	}
+	observeHandshake("client", curveName(newConn.kex), newConn.hrr, "ok", handshakeDuration)
+
+	telemetry := buildTelemetry(conn.ConnectionState(), tcpConn, dialDuration, handshakeDuration)
+	res.Ok = true
+	res.Kex = newConn.kex
+	res.KexName = curveName(newConn.kex)
+	res.HRR = newConn.hrr
+	res.Telemetry = &telemetry
+	return res
+}
+
+// batchHandler serves POST /batch: it runs every target through a bounded
+// worker pool and streams one JSON result per line as each completes, so a
+// caller sees progress on a large scan instead of waiting for all of it.
+func batchHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		errResp(w, 405, "method not allowed")
+		return
+	}
+
+	var breq BatchRequest
+	if err := json.NewDecoder(req.Body).Decode(&breq); err != nil {
+		errResp(w, 400, "can't parse body: %v", err)
+		return
+	}
+
+	timeout := probeTimeout
+	if breq.Timeout != "" {
+		d, err := time.ParseDuration(breq.Timeout)
+		if err != nil {
+			errResp(w, 400, "invalid timeout: %v", err)
+			return
+		}
+		timeout = d
+	}
+
+	concurrency := breq.Concurrency
+	if concurrency <= 0 {
+		concurrency = probeConcurrency
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	var writeMu sync.Mutex
+
+	ctx := req.Context()
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, target := range breq.Targets {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, target BatchTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			res := batchHandshake(ctx, target, timeout)
+			res.Index = i
+
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			enc.Encode(&res)
+			bw.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}(i, target)
+	}
+	wg.Wait()
+}
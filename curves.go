@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// curveInfo describes one CurveID this tool knows how to offer, under the
+// symbolic name callers use in the curves= form field and the /curves
+// catalog.
+type curveInfo struct {
+	Name string      `json:"name"`
+	ID   tls.CurveID `json:"id"`
+}
+
+// allCurves is the full catalog of classical and hybrid groups the
+// underlying cloudflare/go fork can negotiate. It is the allow-list for
+// curves= and the candidate set for method=probe; when curves= is not given,
+// method=supported/preferred still offer the five-entry lists in
+// defaultCurvesForMethod.
+//
+// There is no pure (non-hybrid) ML-KEM group here: the fork this tool is
+// built against only exposes ML-KEM wired up as a hybrid with X25519
+// (X25519MLKEM768); IANA's TLS group registry has no assigned code point
+// for ML-KEM on its own. Add one here if and when the fork gains it.
+var allCurves = []curveInfo{
+	{"x25519", tls.X25519},
+	{"p256", tls.CurveP256},
+	{"p384", tls.CurveP384},
+	{"p521", tls.CurveP521},
+	{"x25519kyber512draft00", tls.X25519Kyber512Draft00},
+	{"x25519kyber768draft00", tls.X25519Kyber768Draft00},
+	{"p256kyber768draft00", tls.P256Kyber768Draft00},
+	{"x25519mlkem768", tls.X25519MLKEM768},
+}
+
+// curveByName and curveByID index allCurves for lookups in both
+// directions.
+var curveByName = func() map[string]curveInfo {
+	m := make(map[string]curveInfo, len(allCurves))
+	for _, c := range allCurves {
+		m[c.Name] = c
+	}
+	return m
+}()
+
+var curveByID = func() map[tls.CurveID]curveInfo {
+	m := make(map[tls.CurveID]curveInfo, len(allCurves))
+	for _, c := range allCurves {
+		m[c.ID] = c
+	}
+	return m
+}()
+
+// curveName returns the symbolic name for id, or its hex code point if it's
+// not in allCurves.
+func curveName(id tls.CurveID) string {
+	if c, ok := curveByID[id]; ok {
+		return c.Name
+	}
+	return fmt.Sprintf("0x%04x", uint16(id))
+}
+
+// parseCurves parses a comma-separated curves= form value, where each
+// entry is either a symbolic name ("x25519", "x25519mlkem768") or a raw
+// hex code point ("0xfe31"). It rejects anything not on the allow-list.
+func parseCurves(s string) ([]tls.CurveID, error) {
+	var ids []tls.CurveID
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(strings.ToLower(part))
+		if part == "" {
+			continue
+		}
+		if strings.HasPrefix(part, "0x") {
+			n, err := strconv.ParseUint(part[2:], 16, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid curve code point %q: %v", part, err)
+			}
+			id := tls.CurveID(n)
+			if _, ok := curveByID[id]; !ok {
+				return nil, fmt.Errorf("curve %q is not on the allow-list", part)
+			}
+			ids = append(ids, id)
+			continue
+		}
+		c, ok := curveByName[part]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", part)
+		}
+		ids = append(ids, c.ID)
+	}
+	return ids, nil
+}
+
+// curvesHandler serves GET /curves, the catalog of groups this tool knows
+// how to offer.
+func curvesHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(allCurves)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultCurvesForMethod returns the curve list for the legacy
+// method=supported/preferred form values, used when no explicit curves=
+// field is given.
+func defaultCurvesForMethod(method string) ([]tls.CurveID, error) {
+	switch method {
+	case "supported":
+		return []tls.CurveID{
+			tls.X25519,
+			tls.CurveP256,
+			tls.CurveP384,
+			tls.X25519Kyber768Draft00,
+			tls.X25519MLKEM768,
+		}, nil
+	case "preferred", "":
+		return []tls.CurveID{
+			tls.X25519MLKEM768,
+			tls.X25519Kyber768Draft00,
+			tls.X25519,
+			tls.CurveP256,
+			tls.CurveP384,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown method %q", method)
+	}
+}
+
+// dialOptions bundles the outbound TLS config knobs handler and batch
+// assemble from form fields/JSON, so dialTLS doesn't need to keep growing
+// positional parameters.
+type dialOptions struct {
+	Curves   []tls.CurveID
+	ALPN     string
+	Insecure bool
+	Cert     *tls.Certificate
+	RootCAs  *x509.CertPool
+}
+
+// dialTLS dials remote and wraps the connection in a tls.Client configured
+// per opts. It does not perform the handshake itself: callers drive and
+// time that via the returned context, which carries the CFEvent handler
+// needed to observe the negotiated KEX and any HRR.
+func dialTLS(ctx context.Context, remote, serverName string, opts dialOptions) (*tls.Conn, *Conn, *timingConn, context.Context, time.Duration, error) {
+	newConn := &Conn{}
+	ctx = context.WithValue(ctx, tls.CFEventHandlerContextKey{}, newConn.eventHandler)
+
+	dialStart := time.Now()
+	rawConn, err := (&net.Dialer{}).DialContext(ctx, "tcp", remote)
+	if err != nil {
+		return nil, nil, nil, nil, 0, err
+	}
+	dialDuration := time.Since(dialStart)
+	tcpConn := &timingConn{Conn: rawConn}
+
+	tlsConfig := &tls.Config{
+		CurvePreferences:   opts.Curves,
+		ServerName:         serverName,
+		InsecureSkipVerify: opts.Insecure,
+		RootCAs:            opts.RootCAs,
+	}
+	if opts.ALPN != "" {
+		tlsConfig.NextProtos = strings.Split(opts.ALPN, ",")
+	}
+	if opts.Cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*opts.Cert}
+	}
+
+	return tls.Client(tcpConn, tlsConfig), newConn, tcpConn, ctx, dialDuration, nil
+}
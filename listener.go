@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// connMeta associates the *Conn metadata recorded during the inbound
+// handshake with the *tls.Conn that ConnContext later receives for the
+// same connection, so handler can still report Kex/HRR without re-running
+// or re-timing the handshake itself.
+var connMeta sync.Map // map[*tls.Conn]*Conn
+
+// inboundHandshakeTimeout bounds how long an inbound TLS handshake may
+// take before it's abandoned, so a client that opens a connection and
+// never completes (or drip-feeds) its ClientHello can't tie up a worker
+// goroutine forever.
+const inboundHandshakeTimeout = 10 * time.Second
+
+// tlsMetricsListener performs the inbound TLS handshake itself, instead of
+// leaving it to net/http, so a handshake that fails before any request is
+// ever served (bad cert, rejected curve, alert, timeout) still shows up in
+// pqtester_handshakes_total. Successful handshakes are timed from just before
+// the handshake starts; failed ones get no duration observation. Each handshake runs
+// in its own goroutine, same as net/http would do it, so one slow or stuck
+// client can't stall Accept() for every other connection.
+type tlsMetricsListener struct {
+	net.Listener
+	config *tls.Config
+
+	conns chan net.Conn
+	errs  chan error
+}
+
+func newTLSMetricsListener(inner net.Listener, config *tls.Config) *tlsMetricsListener {
+	l := &tlsMetricsListener{
+		Listener: inner,
+		config:   config,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error, 1),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *tlsMetricsListener) acceptLoop() {
+	for {
+		rawConn, err := l.Listener.Accept()
+		if err != nil {
+			l.errs <- err
+			return
+		}
+		go l.handshake(rawConn)
+	}
+}
+
+// handshake runs the TLS handshake for one freshly-accepted connection,
+// records its outcome, and hands the result to Accept. It never blocks the
+// acceptLoop: a stuck client only ties up this one goroutine until
+// inboundHandshakeTimeout fires.
+func (l *tlsMetricsListener) handshake(rawConn net.Conn) {
+	meta := &Conn{start: time.Now()}
+	ctx, cancel := context.WithTimeout(context.Background(), inboundHandshakeTimeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, tls.CFEventHandlerContextKey{}, meta.eventHandler)
+	tlsConn := tls.Server(rawConn, l.config)
+
+	inflight.Inc()
+	hsErr := tlsConn.HandshakeContext(ctx)
+	duration := time.Since(meta.start)
+	inflight.Dec()
+
+	result := "ok"
+	if hsErr != nil {
+		result = "error"
+	}
+	observeHandshake("server", curveName(meta.kex), meta.hrr, result, duration)
+
+	if hsErr != nil {
+		tlsConn.Close()
+		return
+	}
+	connMeta.Store(tlsConn, meta)
+	l.conns <- tlsConn
+}
+
+func (l *tlsMetricsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	}
+}
+
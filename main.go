@@ -4,11 +4,17 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type ConnContextKey struct{}
@@ -16,6 +22,10 @@ type ConnContextKey struct{}
 type Conn struct {
 	kex tls.CurveID
 	hrr bool
+
+	// start is only used on the inbound (server) side, where
+	// tlsMetricsListener stamps it right before the handshake begins.
+	start time.Time
 }
 
 func (c *Conn) eventHandler(ev tls.CFEvent) {
@@ -33,6 +43,146 @@ func errResp(w http.ResponseWriter, status int, msg string, args ...any) {
 	fmt.Fprintf(w, msg, args...)
 }
 
+// probeCurves is the set of candidate groups offered, one at a time, by
+// method=probe. It defaults to the full catalog in curves.go.
+var probeCurves = func() []tls.CurveID {
+	ids := make([]tls.CurveID, len(allCurves))
+	for i, c := range allCurves {
+		ids[i] = c.ID
+	}
+	return ids
+}()
+
+const probeTimeout = 5 * time.Second
+const probeConcurrency = 8
+
+// ProbeResult is the outcome of offering a single candidate curve to the
+// remote in isolation.
+type ProbeResult struct {
+	Curve     tls.CurveID `json:"curve"`
+	CurveName string      `json:"curveName"`
+	Ok        bool        `json:"ok"`
+	HRR       bool        `json:"hrr"`
+	Alert     string      `json:"alert,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// ProbeResponse is the response body for method=probe.
+type ProbeResponse struct {
+	Remote    string        `json:"remote"`
+	Results   []ProbeResult `json:"results"`
+	Supported []tls.CurveID `json:"supported"`
+	Preferred tls.CurveID   `json:"preferred,omitempty"`
+	HRRWhen   []tls.CurveID `json:"hrrWhen"`
+}
+
+// dialAndHandshake dials remote fresh and offers curves, reporting the
+// negotiated group (if any), whether a HelloRetryRequest was triggered, and
+// any TLS alert the peer sent back. dialErr and hsErr are reported
+// separately so callers can tell an unreachable remote from a rejected
+// candidate.
+func dialAndHandshake(ctx context.Context, remote, serverName string, insecure bool, curves []tls.CurveID) (kex tls.CurveID, hrr bool, alert string, dialErr, hsErr error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	conn := &Conn{}
+	ctx = context.WithValue(ctx, tls.CFEventHandlerContextKey{}, conn.eventHandler)
+
+	tcpConn, dialErr := (&net.Dialer{}).DialContext(ctx, "tcp", remote)
+	if dialErr != nil {
+		return 0, false, "", dialErr, nil
+	}
+	defer tcpConn.Close()
+
+	tlsConn := tls.Client(tcpConn, &tls.Config{
+		CurvePreferences:   curves,
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+	})
+	defer tlsConn.Close()
+
+	hsErr = tlsConn.HandshakeContext(ctx)
+	var ae tls.AlertError
+	var opErr *net.OpError
+	if errors.As(hsErr, &ae) {
+		alert = ae.Error()
+	} else if errors.As(hsErr, &opErr) && opErr.Op == "remote error" {
+		alert = opErr.Err.Error()
+	}
+	return conn.kex, conn.hrr, alert, nil, hsErr
+}
+
+// runProbe offers each of probeCurves to remote in turn, over a bounded
+// worker pool, then offers them all at once to find the server's single
+// preferred group. A dial failure on any candidate cancels the rest of the
+// scan right away, since it means the remote is unreachable rather than
+// merely rejecting a group, and there's no point paying for N more dial
+// timeouts to find that out.
+func runProbe(ctx context.Context, remote, serverName string, insecure bool, concurrency int) (*ProbeResponse, error) {
+	results := make([]ProbeResult, len(probeCurves))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var dialErr error
+	var dialErrOnce sync.Once
+
+	for i, curve := range probeCurves {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, curve tls.CurveID) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if ctx.Err() != nil {
+				results[i] = ProbeResult{Curve: curve, CurveName: curveName(curve), Error: "aborted: " + ctx.Err().Error()}
+				return
+			}
+
+			_, hrr, alert, dErr, hsErr := dialAndHandshake(ctx, remote, serverName, insecure, []tls.CurveID{curve})
+			if dErr != nil {
+				dialErrOnce.Do(func() {
+					dialErr = dErr
+					cancel()
+				})
+				results[i] = ProbeResult{Curve: curve, CurveName: curveName(curve), Error: dErr.Error()}
+				return
+			}
+			if hsErr != nil {
+				results[i] = ProbeResult{Curve: curve, CurveName: curveName(curve), HRR: hrr, Alert: alert, Error: hsErr.Error()}
+				return
+			}
+			results[i] = ProbeResult{Curve: curve, CurveName: curveName(curve), Ok: true, HRR: hrr, Alert: alert}
+		}(i, curve)
+	}
+	wg.Wait()
+
+	if dialErr != nil {
+		return nil, dialErr
+	}
+
+	resp := &ProbeResponse{Remote: remote, Results: results}
+	for _, r := range results {
+		if r.Ok {
+			resp.Supported = append(resp.Supported, r.Curve)
+		}
+		if r.HRR {
+			resp.HRRWhen = append(resp.HRRWhen, r.Curve)
+		}
+	}
+
+	if pref, _, _, _, hsErr := dialAndHandshake(ctx, remote, serverName, insecure, probeCurves); hsErr == nil {
+		resp.Preferred = pref
+	}
+
+	return resp, nil
+}
+
 func handler(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	if req.Method == "POST" {
@@ -41,80 +191,111 @@ func handler(w http.ResponseWriter, req *http.Request) {
 			errResp(w, 400, "can't parse form: %v", err)
 			return
 		}
-		newConn := &Conn{}
 		remote := req.PostFormValue("remote")
-		newCtx := context.WithValue(
-			ctx,
-			tls.CFEventHandlerContextKey{},
-			newConn.eventHandler,
-		)
 		remoteHost, _, err := net.SplitHostPort(remote)
 		if err != nil {
 			errResp(w, 400, "can't parse remote: %v", err)
 			return
 		}
-		tcpConn, err := (&net.Dialer{}).DialContext(
-			newCtx,
-			"tcp",
-			remote,
-		)
-		if err != nil {
-			errResp(w, 400, "can't dial: %v", err)
-			return
-		}
-		defer tcpConn.Close()
 		method := req.PostFormValue("method")
-
-		curves := []tls.CurveID{
-			tls.X25519,
-			tls.CurveP256,
-			tls.CurveP384,
-			tls.X25519Kyber768Draft00,
-			tls.X25519MLKEM768,
+		serverName := remoteHost
+		if req.PostFormValue("servername") != "" {
+			serverName = req.PostFormValue("servername")
 		}
+		insecure := req.PostFormValue("insecure") != ""
 
-		if method == "supported" {
-		} else if method == "preferred" || method == "" {
-			curves = []tls.CurveID{
-				tls.X25519MLKEM768,
-				tls.X25519Kyber768Draft00,
-				tls.X25519,
-				tls.CurveP256,
-				tls.CurveP384,
+		if method == "probe" || method == "enumerate" {
+			concurrency := probeConcurrency
+			if v := req.PostFormValue("concurrency"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					errResp(w, 400, "invalid concurrency: %v", v)
+					return
+				}
+				concurrency = n
+			}
+			resp, err := runProbe(ctx, remote, serverName, insecure, concurrency)
+			if err != nil {
+				errResp(w, 400, "can't dial: %v", err)
+				return
 			}
-		} else {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		curves, err := defaultCurvesForMethod(method)
+		if err != nil {
 			errResp(w, 400, "unknown method")
 			return
 		}
-		serverName := remoteHost
-		if req.PostFormValue("servername") != "" {
-			serverName = req.PostFormValue("servername")
+		if v := req.PostFormValue("curves"); v != "" {
+			parsed, err := parseCurves(v)
+			if err != nil {
+				errResp(w, 400, "invalid curves: %v", err)
+				return
+			}
+			curves = parsed
 		}
 
-		insecure := req.PostFormValue("insecure") != ""
+		clientCert, err := clientCertificate(req.PostFormValue("cert"), req.PostFormValue("key"))
+		if err != nil {
+			errResp(w, 400, "invalid client certificate: %v", err)
+			return
+		}
+		rootCAs, err := rootCAPool(req.PostFormValue("rootCAs"))
+		if err != nil {
+			errResp(w, 400, "invalid rootCAs: %v", err)
+			return
+		}
 
-		conn := tls.Client(tcpConn, &tls.Config{
-			CurvePreferences:   curves,
-			ServerName:         serverName,
-			InsecureSkipVerify: insecure,
+		conn, newConn, tcpConn, newCtx, dialDuration, err := dialTLS(ctx, remote, serverName, dialOptions{
+			Curves:   curves,
+			ALPN:     req.PostFormValue("alpn"),
+			Insecure: insecure,
+			Cert:     clientCert,
+			RootCAs:  rootCAs,
 		})
-
+		if err != nil {
+			errResp(w, 400, "can't dial: %v", err)
+			return
+		}
+		defer tcpConn.Close()
 		defer conn.Close()
+
+		inflight.Inc()
+		handshakeStart := time.Now()
 		err = conn.HandshakeContext(newCtx)
+		handshakeDuration := time.Since(handshakeStart)
+		inflight.Dec()
 		if err != nil {
+			observeHandshake("client", curveName(newConn.kex), newConn.hrr, "error", handshakeDuration)
 			errResp(w, 400, "handshake: %v", err)
 			return
 		}
+		observeHandshake("client", curveName(newConn.kex), newConn.hrr, "ok", handshakeDuration)
+
+		certInfo, err := clientCertInfo(clientCert)
+		if err != nil {
+			errResp(w, 400, "can't parse client certificate: %v", err)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		ret := struct {
-			Kex    tls.CurveID
-			HRR    bool
-			Remote string
+			Kex        tls.CurveID
+			KexName    string
+			HRR        bool
+			Remote     string
+			Telemetry  Telemetry
+			ClientCert *CertInfo `json:",omitempty"`
 		}{
-			Kex:    newConn.kex,
-			HRR:    newConn.hrr,
-			Remote: remote,
+			Kex:        newConn.kex,
+			KexName:    curveName(newConn.kex),
+			HRR:        newConn.hrr,
+			Remote:     remote,
+			Telemetry:  buildTelemetry(conn.ConnectionState(), tcpConn, dialDuration, handshakeDuration),
+			ClientCert: certInfo,
 		}
 		json.NewEncoder(w).Encode(&ret)
 
@@ -123,48 +304,65 @@ func handler(w http.ResponseWriter, req *http.Request) {
 	conn := ctx.Value(ConnContextKey{}).(*Conn)
 	w.Header().Set("Content-Type", "application/json")
 	ret := struct {
-		Kex tls.CurveID
-		HRR bool
+		Kex     tls.CurveID
+		KexName string
+		HRR     bool
 	}{
-		Kex: conn.kex,
-		HRR: conn.hrr,
+		Kex:     conn.kex,
+		KexName: curveName(conn.kex),
+		HRR:     conn.hrr,
 	}
 	json.NewEncoder(w).Encode(&ret)
 }
 
 func main() {
 	addr := flag.String("addr", "0.0.0.0:8080", "Address to bind to")
+	flag.StringVar(&certDir, "cert-dir", "", "Directory cert=, key= and rootCAs= form values are resolved against when they aren't inline PEM")
 
 	flag.Parse()
 
 	http.HandleFunc("/", handler)
+	http.HandleFunc("/curves", curvesHandler)
+	http.HandleFunc("/batch", batchHandler)
+	http.Handle("/metrics", promhttp.Handler())
+
+	tlsConfig := &tls.Config{
+		CurvePreferences: []tls.CurveID{
+			tls.X25519Kyber768Draft00,
+			tls.X25519,
+			tls.CurveP256,
+			tls.CurveP384,
+		},
+	}
 
 	log.Printf("Listening on %s", *addr)
 	srv := http.Server{
-		Addr: *addr,
-		TLSConfig: &tls.Config{
-			CurvePreferences: []tls.CurveID{
-				tls.X25519Kyber768Draft00,
-				tls.X25519,
-				tls.CurveP256,
-				tls.CurveP384,
-			},
-		},
-		ConnContext: func(ctx context.Context, _ net.Conn) context.Context {
-			conn := &Conn{}
+		Addr:      *addr,
+		TLSConfig: tlsConfig,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			stored, _ := connMeta.LoadAndDelete(c)
+			meta, _ := stored.(*Conn)
+			if meta == nil {
+				meta = &Conn{start: time.Now()}
+			}
 			return context.WithValue(
 				context.WithValue(
 					ctx,
 					tls.CFEventHandlerContextKey{},
-					conn.eventHandler,
+					meta.eventHandler,
 				),
 				ConnContextKey{},
-				conn,
+				meta,
 			)
 		},
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
-		log.Fatalf("ListenAndServe: %v", err)
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("listen: %v", err)
+	}
+
+	if err := srv.Serve(newTLSMetricsListener(ln, tlsConfig)); err != nil {
+		log.Fatalf("Serve: %v", err)
 	}
 }
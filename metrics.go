@@ -0,0 +1,40 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	handshakesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pqtester_handshakes_total",
+		Help: "TLS handshakes performed, by side, negotiated KEX, whether a HelloRetryRequest was seen, and outcome.",
+	}, []string{"side", "kex", "hrr", "result"})
+
+	handshakeDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pqtester_handshake_duration_seconds",
+		Help:    "Handshake duration in seconds, by side and negotiated KEX.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"side", "kex"})
+
+	inflight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pqtester_inflight",
+		Help: "TLS handshakes currently in progress.",
+	})
+)
+
+// observeHandshake records a completed handshake's outcome and, if it
+// succeeded, its duration. kex should already be resolved to its symbolic
+// name via curveName so dashboards don't have to know raw code points.
+func observeHandshake(side, kex string, hrr bool, result string, duration time.Duration) {
+	hrrLabel := "false"
+	if hrr {
+		hrrLabel = "true"
+	}
+	handshakesTotal.WithLabelValues(side, kex, hrrLabel, result).Inc()
+	if result == "ok" {
+		handshakeDurationSeconds.WithLabelValues(side, kex).Observe(duration.Seconds())
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// certDir is the base directory cert=, key= and rootCAs= values that
+// aren't inline PEM are resolved against. Set via -cert-dir.
+var certDir string
+
+// loadPEMOrFile returns v's bytes: if v looks like inline PEM, it's used
+// directly; otherwise it's treated as a filename resolved under certDir.
+// The resolved path is required to stay within certDir, so a caller can't
+// use ".." or an absolute path to make the server read arbitrary files.
+func loadPEMOrFile(v string) ([]byte, error) {
+	if strings.Contains(v, "-----BEGIN") {
+		return []byte(v), nil
+	}
+	if certDir == "" {
+		return nil, fmt.Errorf("%q is not inline PEM and -cert-dir is not set", v)
+	}
+	path := filepath.Join(certDir, v)
+	if rel, err := filepath.Rel(certDir, path); err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("%q escapes -cert-dir", v)
+	}
+	return os.ReadFile(path)
+}
+
+// clientCertificate builds a tls.Certificate from the cert= and key= form
+// fields, each either inline PEM or a filename under -cert-dir. It returns
+// nil, nil if neither field was given.
+func clientCertificate(certField, keyField string) (*tls.Certificate, error) {
+	if certField == "" && keyField == "" {
+		return nil, nil
+	}
+	if certField == "" || keyField == "" {
+		return nil, fmt.Errorf("cert and key must both be given")
+	}
+	certPEM, err := loadPEMOrFile(certField)
+	if err != nil {
+		return nil, fmt.Errorf("cert: %v", err)
+	}
+	keyPEM, err := loadPEMOrFile(keyField)
+	if err != nil {
+		return nil, fmt.Errorf("key: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// rootCAPool builds an x509.CertPool from the rootCAs= form field, either
+// inline PEM or a filename under -cert-dir, to override the system pool
+// instead of disabling verification outright.
+func rootCAPool(rootCAsField string) (*x509.CertPool, error) {
+	if rootCAsField == "" {
+		return nil, nil
+	}
+	pem, err := loadPEMOrFile(rootCAsField)
+	if err != nil {
+		return nil, fmt.Errorf("rootCAs: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("rootCAs: no certificates found")
+	}
+	return pool, nil
+}
+
+// clientCertInfo summarizes the leaf of cert for the response, so callers
+// can confirm what was actually presented to the server.
+func clientCertInfo(cert *tls.Certificate) (*CertInfo, error) {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil, nil
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	info := certChainInfo([]*x509.Certificate{leaf})[0]
+	return &info, nil
+}
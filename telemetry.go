@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"time"
+)
+
+// timingConn wraps a net.Conn to record when the first byte is written
+// (the ClientHello going out) and when the first byte comes back (the
+// start of the ServerHello), so handler can report that leg of the
+// handshake separately from the full handshake duration.
+type timingConn struct {
+	net.Conn
+	firstWrite time.Time
+	firstRead  time.Time
+}
+
+func (c *timingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if c.firstWrite.IsZero() && n > 0 {
+		c.firstWrite = time.Now()
+	}
+	return n, err
+}
+
+func (c *timingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.firstRead.IsZero() && n > 0 {
+		c.firstRead = time.Now()
+	}
+	return n, err
+}
+
+// CertInfo summarizes the fields of a peer certificate we care about for
+// PQ-readiness probing.
+type CertInfo struct {
+	Subject    string    `json:"subject"`
+	Issuer     string    `json:"issuer"`
+	SANs       []string  `json:"sans,omitempty"`
+	NotAfter   time.Time `json:"notAfter"`
+	SPKISHA256 string    `json:"spkiSha256"`
+}
+
+// subjectAltNames collects every SAN type x509.Certificate parses (DNS
+// names, IP addresses, email addresses and URIs) into one list, since
+// mTLS-probed internal services are often IP-SAN-addressed rather than
+// DNS-named.
+func subjectAltNames(cert *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+func certChainInfo(chain []*x509.Certificate) []CertInfo {
+	infos := make([]CertInfo, len(chain))
+	for i, cert := range chain {
+		spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		infos[i] = CertInfo{
+			Subject:    cert.Subject.String(),
+			Issuer:     cert.Issuer.String(),
+			SANs:       subjectAltNames(cert),
+			NotAfter:   cert.NotAfter,
+			SPKISHA256: hex.EncodeToString(spki[:]),
+		}
+	}
+	return infos
+}
+
+// Telemetry is the handshake detail handler reports alongside the
+// negotiated KEX group.
+type Telemetry struct {
+	TLSVersion               string        `json:"tlsVersion"`
+	CipherSuite              string        `json:"cipherSuite"`
+	ALPN                     string        `json:"alpn,omitempty"`
+	CertChain                []CertInfo    `json:"certChain,omitempty"`
+	SCTCount                 int           `json:"sctCount"`
+	OCSPStapled              bool          `json:"ocspStapled"`
+	DialDuration             time.Duration `json:"dialDuration"`
+	ClientHelloToServerHello time.Duration `json:"clientHelloToServerHello"`
+	HandshakeDuration        time.Duration `json:"handshakeDuration"`
+}
+
+func buildTelemetry(cs tls.ConnectionState, tc *timingConn, dialDuration, handshakeDuration time.Duration) Telemetry {
+	t := Telemetry{
+		TLSVersion:        tls.VersionName(cs.Version),
+		CipherSuite:       tls.CipherSuiteName(cs.CipherSuite),
+		ALPN:              cs.NegotiatedProtocol,
+		CertChain:         certChainInfo(cs.PeerCertificates),
+		SCTCount:          len(cs.SignedCertificateTimestamps),
+		OCSPStapled:       len(cs.OCSPResponse) > 0,
+		DialDuration:      dialDuration,
+		HandshakeDuration: handshakeDuration,
+	}
+	if !tc.firstWrite.IsZero() && !tc.firstRead.IsZero() && tc.firstRead.After(tc.firstWrite) {
+		t.ClientHelloToServerHello = tc.firstRead.Sub(tc.firstWrite)
+	}
+	return t
+}